@@ -0,0 +1,296 @@
+package fixtures
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// defaultBatchSize is used when LoadOptions.BulkInsert is set but
+// LoadOptions.BatchSize is left at its zero value.
+const defaultBatchSize = 500
+
+// rowBatch is a run of consecutive rows bound for the same table with the
+// same set of columns, which can be loaded with a single multi-row INSERT
+// (or COPY, on Postgres) instead of one INSERT/UPDATE per row.
+type rowBatch struct {
+	table   TableRef
+	columns []string
+	// pkColumns holds batch's primary-key column(s), re-quoted like
+	// columns, so that upsert helpers don't have to assume "id".
+	pkColumns []string
+	// rawColumns holds Row.GetInsertColumns()'s untouched (Postgres-quoted)
+	// output, kept around only to detect where one batch's column set ends
+	// and the next begins.
+	rawColumns []string
+	rows       []Row
+}
+
+// loadRowsBulk is the BulkInsert code path for loadRows: it groups
+// consecutive rows into batches of up to opts.BatchSize rows sharing a
+// table and column set, and upserts each batch with a single statement
+// instead of probing for existence row by row.
+func loadRowsBulk(rows []Row, db *sql.DB, driver string, helper Helper, registry *refRegistry, opts LoadOptions, tableRowCount map[string]int) error {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	for _, row := range rows {
+		row.Init()
+	}
+
+	touchedTables := make([]TableRef, 0, len(rows))
+	seenTables := make(map[string]bool)
+
+	return withTx(db, helper, func(tx *sql.Tx) error {
+		for _, batch := range batchRows(rows, batchSize, helper) {
+			if !seenTables[batch.table.Qualified()] {
+				seenTables[batch.table.Qualified()] = true
+				touchedTables = append(touchedTables, batch.table)
+			}
+
+			err := helper.WhileInsertOnTable(tx, batch.table.Table, func() error {
+				if driver == postgresDriver {
+					return bulkCopyUpsert(tx, batch)
+				}
+				return bulkUpsert(tx, driver, helper, batch)
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, row := range batch.rows {
+				label := strconv.Itoa(tableRowCount[batch.table.Table])
+				tableRowCount[batch.table.Table]++
+				if pk := row.GetPKValues(); len(pk) > 0 {
+					registry.set(batch.table.Table, label, pk[0])
+				}
+			}
+		}
+
+		return helper.AfterLoad(tx, touchedTables)
+	})
+}
+
+// batchRows groups consecutive rows with the same table and column set into
+// batches of at most batchSize rows. Columns are re-quoted to helper's
+// dialect, since Row.GetInsertColumns() always quotes Postgres-style.
+func batchRows(rows []Row, batchSize int, helper Helper) []rowBatch {
+	var batches []rowBatch
+
+	for _, row := range rows {
+		table := splitTableSchema(row.Table)
+		rawColumns := row.GetInsertColumns()
+
+		if n := len(batches); n > 0 {
+			last := &batches[n-1]
+			if last.table == table && sameColumns(last.rawColumns, rawColumns) && len(last.rows) < batchSize {
+				last.rows = append(last.rows, row)
+				continue
+			}
+		}
+
+		columns := make([]string, len(rawColumns))
+		for i, column := range rawColumns {
+			columns[i] = requoteColumn(helper, column)
+		}
+
+		rawPKColumns := pkColumns(row)
+		batchPKColumns := make([]string, len(rawPKColumns))
+		for i, column := range rawPKColumns {
+			batchPKColumns[i] = requoteColumn(helper, column)
+		}
+
+		batches = append(batches, rowBatch{
+			table:      table,
+			columns:    columns,
+			pkColumns:  batchPKColumns,
+			rawColumns: rawColumns,
+			rows:       []Row{row},
+		})
+	}
+
+	return batches
+}
+
+// isPKColumn reports whether column (already requoted to helper's dialect)
+// is one of batch's primary-key columns.
+func isPKColumn(batch rowBatch, column string) bool {
+	for _, pk := range batch.pkColumns {
+		if pk == column {
+			return true
+		}
+	}
+	return false
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// bulkUpsert loads batch with a single multi-row INSERT statement, using
+// the driver's native upsert syntax (or a MERGE, on SQL Server) to fall
+// back to an UPDATE for rows whose primary key already exists.
+func bulkUpsert(tx *sql.Tx, driver string, helper Helper, batch rowBatch) error {
+	var placeholderRows []string
+	var values []interface{}
+	position := 1
+
+	for _, row := range batch.rows {
+		placeholders := make([]string, len(batch.columns))
+		for i := range placeholders {
+			placeholders[i] = helper.Placeholder(position)
+			position++
+		}
+		placeholderRows = append(placeholderRows, "("+strings.Join(placeholders, ", ")+")")
+		values = append(values, row.GetInsertValues()...)
+	}
+
+	if driver == mssqlDriver {
+		query := bulkMSSQLMerge(helper, batch, placeholderRows)
+		_, err := tx.Exec(query, values...)
+		return err
+	}
+
+	query := fmt.Sprintf(
+		`INSERT INTO %s(%s) VALUES %s %s`,
+		batch.table.Quoted(helper),
+		strings.Join(batch.columns, ", "),
+		strings.Join(placeholderRows, ", "),
+		upsertClause(driver, batch),
+	)
+
+	_, err := tx.Exec(query, values...)
+	return err
+}
+
+// bulkMSSQLMerge builds a MERGE statement that upserts placeholderRows
+// (each already rendered as "(@p1, @p2, ...)") into batch.table, matching
+// existing rows on batch.pkColumns.
+func bulkMSSQLMerge(helper Helper, batch rowBatch, placeholderRows []string) string {
+	var onClauses, updateSets, insertCols []string
+	for _, column := range batch.columns {
+		insertCols = append(insertCols, "src."+column)
+		if isPKColumn(batch, column) {
+			onClauses = append(onClauses, fmt.Sprintf("tgt.%s = src.%s", column, column))
+			continue
+		}
+		updateSets = append(updateSets, fmt.Sprintf("tgt.%s = src.%s", column, column))
+	}
+
+	return fmt.Sprintf(`
+		MERGE INTO %s AS tgt
+		USING (VALUES %s) AS src(%s)
+		ON %s
+		WHEN MATCHED THEN UPDATE SET %s
+		WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);`,
+		batch.table.Quoted(helper),
+		strings.Join(placeholderRows, ", "),
+		strings.Join(batch.columns, ", "),
+		strings.Join(onClauses, " AND "),
+		strings.Join(updateSets, ", "),
+		strings.Join(batch.columns, ", "),
+		strings.Join(insertCols, ", "),
+	)
+}
+
+// upsertClause returns the driver-specific suffix that turns a multi-row
+// INSERT into an upsert keyed on batch's primary key column(s). Not used
+// for SQL Server, which has no INSERT ... ON CONFLICT shorthand and is
+// handled by bulkMSSQLMerge instead.
+func upsertClause(driver string, batch rowBatch) string {
+	var sets []string
+	for _, column := range batch.columns {
+		if isPKColumn(batch, column) {
+			continue
+		}
+		switch driver {
+		case mysqlDriver:
+			sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", column, column))
+		default:
+			sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		}
+	}
+
+	switch driver {
+	case mysqlDriver:
+		return fmt.Sprintf("ON DUPLICATE KEY UPDATE %s", strings.Join(sets, ", "))
+	default: // postgresDriver, sqliteDriver
+		return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(batch.pkColumns, ", "), strings.Join(sets, ", "))
+	}
+}
+
+// bulkCopyUpsert loads batch on Postgres by COPYing it into a temporary
+// staging table (fast, no per-row round trip) and then upserting from the
+// staging table into the real one in a single statement.
+func bulkCopyUpsert(tx *sql.Tx, batch rowBatch) error {
+	stagingTable := "fixtures_staging_" + batch.table.Table
+
+	createStaging := fmt.Sprintf(
+		`CREATE TEMPORARY TABLE IF NOT EXISTS %s (LIKE %s INCLUDING ALL) ON COMMIT DROP`,
+		pq.QuoteIdentifier(stagingTable), batch.table.Quoted(&postgresHelper{}),
+	)
+	if _, err := tx.Exec(createStaging); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`TRUNCATE %s`, pq.QuoteIdentifier(stagingTable))); err != nil {
+		return err
+	}
+
+	bareColumns := make([]string, len(batch.columns))
+	for i, c := range batch.columns {
+		bareColumns[i] = strings.Trim(c, `"`)
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn(stagingTable, bareColumns...))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range batch.rows {
+		if _, err := stmt.Exec(row.GetInsertValues()...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	sets := make([]string, 0, len(batch.columns))
+	for _, column := range batch.columns {
+		if isPKColumn(batch, column) {
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+	}
+
+	upsert := fmt.Sprintf(
+		`INSERT INTO %s(%s) SELECT %s FROM %s ON CONFLICT (%s) DO UPDATE SET %s`,
+		batch.table.Quoted(&postgresHelper{}),
+		strings.Join(batch.columns, ", "),
+		strings.Join(batch.columns, ", "),
+		pq.QuoteIdentifier(stagingTable),
+		strings.Join(batch.pkColumns, ", "),
+		strings.Join(sets, ", "),
+	)
+	_, err = tx.Exec(upsert)
+	return err
+}
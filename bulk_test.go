@@ -0,0 +1,34 @@
+package fixtures
+
+import "testing"
+
+func TestSameColumns(t *testing.T) {
+	cases := []struct {
+		a, b []string
+		want bool
+	}{
+		{[]string{`"id"`, `"name"`}, []string{`"id"`, `"name"`}, true},
+		{[]string{`"id"`, `"name"`}, []string{`"id"`}, false},
+		{[]string{`"id"`, `"name"`}, []string{`"id"`, `"email"`}, false},
+		{nil, nil, true},
+	}
+	for _, c := range cases {
+		if got := sameColumns(c.a, c.b); got != c.want {
+			t.Errorf("sameColumns(%v, %v) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestIsPKColumn(t *testing.T) {
+	batch := rowBatch{pkColumns: []string{`"tenant_id"`, `"id"`}}
+
+	if !isPKColumn(batch, `"id"`) {
+		t.Error(`isPKColumn(batch, "id") = false, want true`)
+	}
+	if !isPKColumn(batch, `"tenant_id"`) {
+		t.Error(`isPKColumn(batch, "tenant_id") = false, want true`)
+	}
+	if isPKColumn(batch, `"name"`) {
+		t.Error(`isPKColumn(batch, "name") = true, want false`)
+	}
+}
@@ -0,0 +1,275 @@
+package fixtures
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// checksumTable is the name of the table used to track which fixture files
+// have already been loaded, when LoadOptions.SkipTableChecksum is set.
+const checksumTable = "fixtures_loaded"
+
+// LoadDirectory loads every *.yml/*.yaml fixture file found recursively
+// under dir, in lexical order. Files are conventionally named with a
+// numeric prefix (e.g. 01_users.yml, 02_posts.yml) so that rows with
+// foreign keys are loaded after the rows they point to.
+//
+// Before loading, LoadDirectory checks (via EnsureTestDatabase) that db
+// looks like a test database, then truncates every table referenced by a
+// fixture file under dir, with referential-integrity checks disabled for
+// the duration, so that LoadDirectory can be called repeatedly against the
+// same database to reset it to a known state.
+func LoadDirectory(dir string, db *sql.DB, driver string) error {
+	return LoadDirectoryWithOptions(dir, db, driver, LoadOptions{})
+}
+
+// LoadDirectoryWithOptions is LoadDirectory with LoadOptions support, in
+// particular LoadOptions.SkipTableChecksum.
+func LoadDirectoryWithOptions(dir string, db *sql.DB, driver string, opts LoadOptions) error {
+	if !opts.SkipDatabaseNameCheck {
+		if err := EnsureTestDatabase(db, driver, opts.DatabaseNameRegexp); err != nil {
+			return err
+		}
+	}
+
+	files, err := findFixtureFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	helper, err := NewHelper(driver)
+	if err != nil {
+		return err
+	}
+
+	tables, err := tablesForFiles(files)
+	if err != nil {
+		return err
+	}
+
+	if len(tables) > 0 {
+		if err := truncateTables(db, helper, driver, tables); err != nil {
+			return err
+		}
+	}
+
+	if opts.SkipTableChecksum {
+		if err := ensureChecksumTable(db, driver); err != nil {
+			return err
+		}
+	}
+
+	// Shared across every file in dir, so that a row's `ref` can resolve
+	// against a row loaded from an earlier file, not just an earlier row in
+	// the same file, and so that a table's ref labels keep counting up
+	// across files instead of restarting at "0" and colliding with an
+	// earlier file's rows for the same table.
+	registry := newRefRegistry()
+	tableRowCount := make(map[string]int)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return NewFileError(file, err)
+		}
+
+		if opts.SkipTableChecksum {
+			unchanged, err := checksumUnchanged(db, helper, file, data)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				continue
+			}
+		}
+
+		if err := loadWithRegistry(data, db, driver, opts, registry, tableRowCount); err != nil {
+			return NewFileError(file, err)
+		}
+
+		if opts.SkipTableChecksum {
+			if err := recordChecksum(db, helper, file, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// findFixtureFiles globs dir recursively for *.yml and *.yaml files,
+// returning them in lexical order.
+func findFixtureFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if ext == ".yml" || ext == ".yaml" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// tablesForFiles parses every fixture file's Table values without running
+// any template or insert/update logic, so that truncateTables can be called
+// up front for the whole directory in one go.
+func tablesForFiles(files []string) ([]TableRef, error) {
+	seen := make(map[string]bool)
+	var tables []TableRef
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, NewFileError(file, err)
+		}
+
+		rows, err := parseRows(data)
+		if err != nil {
+			return nil, NewFileError(file, err)
+		}
+
+		for _, row := range rows {
+			row.Init()
+			table := splitTableSchema(row.Table)
+			if !seen[table.Qualified()] {
+				seen[table.Qualified()] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+
+	return tables, nil
+}
+
+// truncateTables empties tables in a single transaction, with
+// referential-integrity checks disabled so that truncation order doesn't
+// matter.
+func truncateTables(db *sql.DB, helper Helper, driver string, tables []TableRef) error {
+	return withTx(db, helper, func(tx *sql.Tx) error {
+		for _, table := range tables {
+			if err := truncateTable(tx, helper, driver, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func truncateTable(tx *sql.Tx, helper Helper, driver string, table TableRef) error {
+	quoted := table.Quoted(helper)
+
+	if driver == mssqlDriver {
+		// TRUNCATE ignores the IDENTITY_INSERT toggle WhileInsertOnTable
+		// uses for inserts; what actually makes truncation order not
+		// matter on SQL Server is relaxing FK constraint checks directly.
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s NOCHECK CONSTRAINT ALL`, quoted)); err != nil {
+			return err
+		}
+		defer tx.Exec(fmt.Sprintf(`ALTER TABLE %s WITH CHECK CHECK CONSTRAINT ALL`, quoted))
+		_, err := tx.Exec(fmt.Sprintf(`TRUNCATE TABLE %s`, quoted))
+		return err
+	}
+
+	if driver == postgresDriver {
+		// TRUNCATE refuses to empty a table referenced by another table's
+		// FK unless that table is truncated too, or CASCADE is used.
+		// CASCADE satisfies that by truncating every referencing table,
+		// not just the ones in tables, so a fixture reset could silently
+		// wipe data in a table that was never part of the fixture set.
+		// Disabling the table's own triggers (which is what enforces the
+		// FK reference) lets TRUNCATE skip that check without touching
+		// any other table instead.
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s DISABLE TRIGGER ALL`, quoted)); err != nil {
+			return err
+		}
+		defer tx.Exec(fmt.Sprintf(`ALTER TABLE %s ENABLE TRIGGER ALL`, quoted))
+		_, err := tx.Exec(fmt.Sprintf(`TRUNCATE TABLE %s`, quoted))
+		return err
+	}
+
+	var query string
+	switch driver {
+	case mysqlDriver:
+		query = fmt.Sprintf(`TRUNCATE TABLE %s`, quoted)
+	case sqliteDriver:
+		query = fmt.Sprintf(`DELETE FROM %s`, quoted)
+	}
+
+	return helper.WhileInsertOnTable(tx, table.Table, func() error {
+		_, err := tx.Exec(query)
+		return err
+	})
+}
+
+// ensureChecksumTable creates the table used to track loaded fixture file
+// hashes, if it doesn't already exist.
+func ensureChecksumTable(db *sql.DB, driver string) error {
+	var ddl string
+	switch driver {
+	case mysqlDriver:
+		ddl = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (file VARCHAR(255) PRIMARY KEY, checksum VARCHAR(64) NOT NULL)`, checksumTable)
+	default:
+		ddl = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (file TEXT PRIMARY KEY, checksum TEXT NOT NULL)`, checksumTable)
+	}
+	_, err := db.Exec(ddl)
+	return err
+}
+
+// checksumUnchanged reports whether file's contents already match the
+// checksum recorded from the last time it was loaded.
+func checksumUnchanged(db *sql.DB, helper Helper, file string, data []byte) (bool, error) {
+	sum := sha256Hex(data)
+
+	var existing string
+	query := fmt.Sprintf(`SELECT checksum FROM %s WHERE file = %s`, checksumTable, helper.Placeholder(1))
+	err := db.QueryRow(query, file).Scan(&existing)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return existing == sum, nil
+}
+
+// recordChecksum stores the hash of file's contents, so that a subsequent
+// LoadDirectoryWithOptions call can skip it if unchanged.
+func recordChecksum(db *sql.DB, helper Helper, file string, data []byte) error {
+	sum := sha256Hex(data)
+
+	deleteQuery := fmt.Sprintf(`DELETE FROM %s WHERE file = %s`, checksumTable, helper.Placeholder(1))
+	if _, err := db.Exec(deleteQuery, file); err != nil {
+		return err
+	}
+
+	insertQuery := fmt.Sprintf(
+		`INSERT INTO %s (file, checksum) VALUES (%s, %s)`,
+		checksumTable, helper.Placeholder(1), helper.Placeholder(2),
+	)
+	_, err := db.Exec(insertQuery, file, sum)
+	return err
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package fixtures
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindFixtureFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"01_users.yml", "02_posts.yaml", "readme.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("- table: x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := findFixtureFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2: %v", len(files), files)
+	}
+	if filepath.Base(files[0]) != "01_users.yml" || filepath.Base(files[1]) != "02_posts.yaml" {
+		t.Errorf("got %v, want 01_users.yml then 02_posts.yaml", files)
+	}
+}
+
+func TestTablesForFilesPreservesSchema(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("- table: tenant1.users\n  id: 1\n- table: users\n  id: 2\n")
+	if err := os.WriteFile(filepath.Join(dir, "01_users.yml"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := findFixtureFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := tablesForFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TableRef{
+		{Schema: "tenant1", Table: "users"},
+		{Table: "users"},
+	}
+	if len(tables) != len(want) {
+		t.Fatalf("got %v, want %v", tables, want)
+	}
+	for i := range want {
+		if tables[i] != want[i] {
+			t.Errorf("tables[%d] = %+v, want %+v", i, tables[i], want[i])
+		}
+	}
+}
+
+func TestSha256Hex(t *testing.T) {
+	got := sha256Hex([]byte("hello"))
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got != want {
+		t.Errorf("sha256Hex(\"hello\") = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,387 @@
+package fixtures
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// quotedIdentifier matches a Postgres-style double-quoted identifier, the
+// only quoting style Row's generated SQL fragments know how to produce.
+var quotedIdentifier = regexp.MustCompile(`"([A-Za-z_][A-Za-z0-9_]*)"`)
+
+// requoteIdentifiers rewrites every Postgres-style "identifier" in sql to
+// helper's quoting style, so that SQL fragments built by Row (which always
+// quotes with double quotes, regardless of driver) work against MySQL,
+// SQLite and SQL Server too.
+func requoteIdentifiers(helper Helper, sql string) string {
+	return quotedIdentifier.ReplaceAllStringFunc(sql, func(match string) string {
+		name := quotedIdentifier.FindStringSubmatch(match)[1]
+		return helper.QuoteKeyword(name)
+	})
+}
+
+// requoteColumn re-quotes a single column name (as returned by
+// Row.GetInsertColumns(), always double-quoted) to helper's dialect.
+func requoteColumn(helper Helper, column string) string {
+	return helper.QuoteKeyword(strings.Trim(column, `"`))
+}
+
+// pkColumns returns row's primary-key column name(s), Postgres-quoted like
+// Row.GetInsertColumns(). Row has no direct accessor for them, so they're
+// pulled out of the WHERE clause GetWhere renders, which is built from
+// exactly the PK columns; the driver/offset arguments only affect
+// placeholder syntax, not which columns appear, so they're fixed here.
+func pkColumns(row Row) []string {
+	where := row.GetWhere(postgresDriver, 0)
+	matches := quotedIdentifier.FindAllStringSubmatch(where, -1)
+	columns := make([]string, len(matches))
+	for i, match := range matches {
+		columns[i] = `"` + match[1] + `"`
+	}
+	return columns
+}
+
+// Supported driver names, matching the value passed as the driver argument
+// to Load, LoadFile and LoadFiles.
+const (
+	postgresDriver = "postgres"
+	mysqlDriver    = "mysql"
+	sqliteDriver   = "sqlite3"
+	mssqlDriver    = "mssql"
+)
+
+// TableRef identifies a table that rows were loaded into during a Load
+// call, so that Helper.AfterLoad can act on exactly the tables touched
+// instead of having to infer them from e.g. a row's first column name.
+type TableRef struct {
+	// Schema is the table's schema, or "" if the fixture didn't qualify
+	// the table name with one.
+	Schema string
+	Table  string
+}
+
+// Qualified returns the schema-qualified table name (schema.table), or
+// just Table if Schema is empty.
+func (t TableRef) Qualified() string {
+	if t.Schema == "" {
+		return t.Table
+	}
+	return t.Schema + "." + t.Table
+}
+
+// Quoted returns the table name quoted per helper's dialect, qualified with
+// its schema (also quoted) if it has one.
+func (t TableRef) Quoted(helper Helper) string {
+	if t.Schema == "" {
+		return helper.QuoteKeyword(t.Table)
+	}
+	return helper.QuoteKeyword(t.Schema) + "." + helper.QuoteKeyword(t.Table)
+}
+
+// Helper abstracts the handful of things that differ between database
+// dialects: how identifiers and placeholders are written, how to suspend
+// referential-integrity checks while inserting fixture data, and how to put
+// the database back into a consistent state once loading is done (e.g.
+// resetting sequences so that rows inserted outside of fixtures don't
+// collide with fixture-assigned primary keys).
+type Helper interface {
+	// QuoteKeyword quotes a table or column name using the dialect's
+	// identifier quoting rules.
+	QuoteKeyword(keyword string) string
+
+	// Placeholder returns the parameter placeholder for the given
+	// 1-indexed position in a query (e.g. "$1" for Postgres, "?" for
+	// MySQL/SQLite, "@p1" for SQL Server).
+	Placeholder(position int) string
+
+	// WhileInsertOnTable runs fn with referential-integrity/identity
+	// checks relaxed for table, restoring them afterwards regardless of
+	// whether fn returns an error.
+	WhileInsertOnTable(tx *sql.Tx, table string, fn func() error) error
+
+	// AfterLoad runs once after all rows have been loaded, to fix up
+	// anything a bulk of raw INSERTs/UPDATEs left inconsistent, such as
+	// auto-increment sequences trailing behind the rows we just wrote.
+	// tables holds every table actually touched during the Load call.
+	AfterLoad(tx *sql.Tx, tables []TableRef) error
+
+	// BeginTx starts a transaction on db, performing any connection-level
+	// setup the dialect needs before WhileInsertOnTable can relax checks
+	// within it. Only SQLite needs this: its foreign_keys pragma is a
+	// documented no-op once a transaction is open, so it has to be
+	// toggled off before BEGIN, on the same connection BEGIN runs on.
+	// Every successful BeginTx call must be matched with a call to EndTx
+	// once the returned transaction has been committed or rolled back.
+	BeginTx(db *sql.DB) (*sql.Tx, error)
+
+	// EndTx undoes whatever BeginTx set up. A no-op for every dialect but
+	// SQLite, which uses it to restore the foreign_keys pragma and
+	// release the connection BeginTx pinned.
+	EndTx(db *sql.DB) error
+}
+
+// withTx runs fn inside a transaction begun via helper.BeginTx, committing
+// it if fn succeeds and rolling it back otherwise, and always calling
+// helper.EndTx once the transaction is done. Callers must not call
+// tx.Commit()/tx.Rollback() themselves.
+func withTx(db *sql.DB, helper Helper, fn func(tx *sql.Tx) error) error {
+	tx, err := helper.BeginTx(db)
+	if err != nil {
+		return err
+	}
+	defer helper.EndTx(db)
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// NewHelper returns the Helper implementation for driver, or an error if the
+// driver isn't supported.
+func NewHelper(driver string) (Helper, error) {
+	switch driver {
+	case postgresDriver:
+		return &postgresHelper{}, nil
+	case mysqlDriver:
+		return &mysqlHelper{}, nil
+	case sqliteDriver:
+		return &sqliteHelper{}, nil
+	case mssqlDriver:
+		return &mssqlHelper{}, nil
+	default:
+		return nil, errUnsupportedDriver(driver)
+	}
+}
+
+// errUnsupportedDriver is returned whenever a driver string isn't one of
+// the supported driver constants.
+func errUnsupportedDriver(driver string) error {
+	return fmt.Errorf("fixtures: unsupported driver %q", driver)
+}
+
+// postgresHelper implements Helper for PostgreSQL.
+type postgresHelper struct{}
+
+func (h *postgresHelper) QuoteKeyword(keyword string) string {
+	return fmt.Sprintf(`"%s"`, keyword)
+}
+
+func (h *postgresHelper) Placeholder(position int) string {
+	return fmt.Sprintf("$%d", position)
+}
+
+func (h *postgresHelper) WhileInsertOnTable(tx *sql.Tx, table string, fn func() error) error {
+	// Postgres has no equivalent of IDENTITY_INSERT; nothing to relax.
+	return fn()
+}
+
+func (h *postgresHelper) AfterLoad(tx *sql.Tx, tables []TableRef) error {
+	for _, table := range tables {
+		if err := fixPostgresSequences(tx, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *postgresHelper) BeginTx(db *sql.DB) (*sql.Tx, error) {
+	return db.Begin()
+}
+
+func (h *postgresHelper) EndTx(db *sql.DB) error {
+	return nil
+}
+
+// mysqlHelper implements Helper for MySQL.
+type mysqlHelper struct{}
+
+func (h *mysqlHelper) QuoteKeyword(keyword string) string {
+	return fmt.Sprintf("`%s`", keyword)
+}
+
+func (h *mysqlHelper) Placeholder(position int) string {
+	return "?"
+}
+
+func (h *mysqlHelper) WhileInsertOnTable(tx *sql.Tx, table string, fn func() error) error {
+	if _, err := tx.Exec(`SET FOREIGN_KEY_CHECKS=0`); err != nil {
+		return err
+	}
+	defer tx.Exec(`SET FOREIGN_KEY_CHECKS=1`)
+	return fn()
+}
+
+func (h *mysqlHelper) AfterLoad(tx *sql.Tx, tables []TableRef) error {
+	// MySQL's AUTO_INCREMENT already tracks the highest value assigned,
+	// including explicit inserts, so there's nothing to reset.
+	return nil
+}
+
+func (h *mysqlHelper) BeginTx(db *sql.DB) (*sql.Tx, error) {
+	return db.Begin()
+}
+
+func (h *mysqlHelper) EndTx(db *sql.DB) error {
+	return nil
+}
+
+// sqliteHelper implements Helper for SQLite. conn holds the connection
+// BeginTx pinned the current transaction to, so that EndTx can restore its
+// foreign_keys pragma on the same connection and release it back to db's
+// pool; it's only ever set between a BeginTx call and its matching EndTx.
+type sqliteHelper struct {
+	conn *sql.Conn
+}
+
+func (h *sqliteHelper) QuoteKeyword(keyword string) string {
+	return fmt.Sprintf(`"%s"`, keyword)
+}
+
+func (h *sqliteHelper) Placeholder(position int) string {
+	return "?"
+}
+
+func (h *sqliteHelper) WhileInsertOnTable(tx *sql.Tx, table string, fn func() error) error {
+	// foreign_keys is already off for the whole transaction, toggled by
+	// BeginTx before it started (SQLite can't change the pragma once a
+	// transaction is open), so there's nothing left to relax per table.
+	return fn()
+}
+
+func (h *sqliteHelper) AfterLoad(tx *sql.Tx, tables []TableRef) error {
+	for _, table := range tables {
+		column, err := sqliteAutoincrementColumn(tx, h, table.Table)
+		if err != nil {
+			return err
+		}
+		if column == "" {
+			// No single-column primary key, so sqlite_sequence doesn't
+			// track this table (AUTOINCREMENT only ever applies to a lone
+			// INTEGER PRIMARY KEY column).
+			continue
+		}
+
+		_, err = tx.Exec(
+			`UPDATE sqlite_sequence SET seq = (SELECT MAX(`+h.QuoteKeyword(column)+`) FROM `+h.QuoteKeyword(table.Table)+`) WHERE name = ?`,
+			table.Table,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeginTx pins a single connection and disables foreign_keys on it before
+// opening a transaction, since the pragma is a documented no-op once a
+// transaction is already open.
+func (h *sqliteHelper) BeginTx(db *sql.DB) (*sql.Tx, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(context.Background(), `PRAGMA foreign_keys=OFF`); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	h.conn = conn
+	return tx, nil
+}
+
+// EndTx restores foreign_keys on the connection BeginTx pinned, then
+// releases it back to db's pool.
+func (h *sqliteHelper) EndTx(db *sql.DB) error {
+	conn := h.conn
+	h.conn = nil
+
+	_, err := conn.ExecContext(context.Background(), `PRAGMA foreign_keys=ON`)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sqliteAutoincrementColumn returns table's primary-key column, or "" if it
+// doesn't have exactly one (e.g. a composite key, or none at all), via
+// PRAGMA table_info rather than assuming the column is always called "id".
+func sqliteAutoincrementColumn(tx *sql.Tx, h *sqliteHelper, table string) (string, error) {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, h.QuoteKeyword(table)))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var pkCols []string
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, columnType string
+		var dfltValue interface{}
+		if err := rows.Scan(&cid, &name, &columnType, &notnull, &dfltValue, &pk); err != nil {
+			return "", err
+		}
+		if pk > 0 {
+			pkCols = append(pkCols, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if len(pkCols) != 1 {
+		return "", nil
+	}
+	return pkCols[0], nil
+}
+
+// mssqlHelper implements Helper for Microsoft SQL Server.
+type mssqlHelper struct{}
+
+func (h *mssqlHelper) QuoteKeyword(keyword string) string {
+	return fmt.Sprintf("[%s]", keyword)
+}
+
+func (h *mssqlHelper) Placeholder(position int) string {
+	return fmt.Sprintf("@p%d", position)
+}
+
+func (h *mssqlHelper) WhileInsertOnTable(tx *sql.Tx, table string, fn func() error) error {
+	on := fmt.Sprintf(`SET IDENTITY_INSERT %s ON`, h.QuoteKeyword(table))
+	off := fmt.Sprintf(`SET IDENTITY_INSERT %s OFF`, h.QuoteKeyword(table))
+	if _, err := tx.Exec(on); err != nil {
+		return err
+	}
+	defer tx.Exec(off)
+	return fn()
+}
+
+func (h *mssqlHelper) AfterLoad(tx *sql.Tx, tables []TableRef) error {
+	for _, table := range tables {
+		q := fmt.Sprintf(`DBCC CHECKIDENT ('%s', RESEED)`, table.Qualified())
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *mssqlHelper) BeginTx(db *sql.DB) (*sql.Tx, error) {
+	return db.Begin()
+}
+
+func (h *mssqlHelper) EndTx(db *sql.DB) error {
+	return nil
+}
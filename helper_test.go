@@ -0,0 +1,65 @@
+package fixtures
+
+import "testing"
+
+func TestRequoteIdentifiers(t *testing.T) {
+	cases := []struct {
+		driver string
+		sql    string
+		want   string
+	}{
+		{postgresDriver, `"id" = 1`, `"id" = 1`},
+		{mysqlDriver, `"id" = 1 AND "name" = 2`, "`id` = 1 AND `name` = 2"},
+		{sqliteDriver, `"id" = 1`, `"id" = 1`},
+		{mssqlDriver, `"id" = 1`, `[id] = 1`},
+	}
+
+	for _, c := range cases {
+		helper, err := NewHelper(c.driver)
+		if err != nil {
+			t.Fatalf("NewHelper(%q): %v", c.driver, err)
+		}
+		if got := requoteIdentifiers(helper, c.sql); got != c.want {
+			t.Errorf("requoteIdentifiers(%s, %q) = %q, want %q", c.driver, c.sql, got, c.want)
+		}
+	}
+}
+
+func TestRequoteColumn(t *testing.T) {
+	helper, err := NewHelper(mysqlDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := requoteColumn(helper, `"id"`), "`id`"; got != want {
+		t.Errorf("requoteColumn(mysql, `\"id\"`) = %q, want %q", got, want)
+	}
+}
+
+func TestTableRefQualifiedAndQuoted(t *testing.T) {
+	helper, err := NewHelper(postgresDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := TableRef{Table: "users"}
+	if got, want := plain.Qualified(), "users"; got != want {
+		t.Errorf("Qualified() = %q, want %q", got, want)
+	}
+	if got, want := plain.Quoted(helper), `"users"`; got != want {
+		t.Errorf("Quoted() = %q, want %q", got, want)
+	}
+
+	qualified := TableRef{Schema: "tenant1", Table: "users"}
+	if got, want := qualified.Qualified(), "tenant1.users"; got != want {
+		t.Errorf("Qualified() = %q, want %q", got, want)
+	}
+	if got, want := qualified.Quoted(helper), `"tenant1"."users"`; got != want {
+		t.Errorf("Quoted() = %q, want %q", got, want)
+	}
+}
+
+func TestNewHelperUnsupportedDriver(t *testing.T) {
+	if _, err := NewHelper("oracle"); err == nil {
+		t.Error("NewHelper(\"oracle\") = nil error, want errUnsupportedDriver")
+	}
+}
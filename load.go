@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v2"
@@ -19,128 +20,196 @@ func NewFileError(filename string, cause error) error {
 	return fmt.Errorf("Error loading file %s: %s", filename, cause.Error())
 }
 
-// Load processes a YAML fixture and inserts/updates the database accordingly
+// Load processes a YAML fixture and inserts/updates the database
+// accordingly. It refuses to run (returning ErrNotTestDatabase) unless db's
+// name matches defaultDatabaseNameRegexp, or the skipDatabaseNameCheckEnvVar
+// environment variable is set; callers needing a different pattern should
+// use LoadWithOptions and LoadOptions.DatabaseNameRegexp instead.
 func Load(data []byte, db *sql.DB, driver string, oneTransactionPerRow ...bool) error {
+	if !skipDatabaseNameCheck() {
+		if err := EnsureTestDatabase(db, driver, nil); err != nil {
+			return err
+		}
+	}
+
+	doOneTransactionPerRow := len(oneTransactionPerRow) > 0 && oneTransactionPerRow[0]
+	return loadRows(data, db, driver, newRefRegistry(), doOneTransactionPerRow, LoadOptions{}, make(map[string]int))
+}
+
+// loadRows unmarshals data as YAML and inserts/updates the database
+// accordingly. registry is used to record the primary-key value of each row
+// as it's loaded, so that the `ref` template func can resolve rows loaded
+// earlier in the same call. tableRowCount tracks each table's next ref
+// label the same way registry tracks its value, and is shared across an
+// entire LoadDirectoryWithOptions call for the same reason: so a file's
+// rows don't restart a table's labels at "0" and collide with an earlier
+// file's.
+func loadRows(data []byte, db *sql.DB, driver string, registry *refRegistry, doOneTransactionPerRow bool, opts LoadOptions, tableRowCount map[string]int) error {
 	// Unmarshal the YAML data into a []Row slice
 	var rows []Row
 	if err := yaml.Unmarshal(data, &rows); err != nil {
 		return err
 	}
 
-	doOneTransactionPerRow := len(oneTransactionPerRow) > 0 && oneTransactionPerRow[0]
+	helper, err := NewHelper(driver)
+	if err != nil {
+		return err
+	}
 
-	var tx *sql.Tx
-	if !doOneTransactionPerRow {
-		// Begin a transaction
-		var err error
-		tx, err = db.Begin()
-		if err != nil {
-			return err
-		}
+	if opts.BulkInsert {
+		return loadRowsBulk(rows, db, driver, helper, registry, opts, tableRowCount)
 	}
 
-	// Iterate over rows define in the fixture
-	for i, row := range rows {
-		if doOneTransactionPerRow {
-			var err error
-			tx, err = db.Begin()
-			if err != nil {
-				return err
-			}
-		}
+	touchedTables := make([]TableRef, 0, len(rows))
+	seenTables := make(map[string]bool)
 
-		// Load struct variables
-		row.Init()
-		s := strings.Split(row.Table, ".")
-		switch {
-			case len(s) > 2:
-				return fmt.Errorf("Table name wrong format in yaml")
-			case len(s) == 2:
-				q := fmt.Sprintf(`SET LOCAL SEARCH_PATH TO %s`, s[0])
-				_, err := tx.Exec(q)
-				if err != nil {
-					tx.Rollback() // rollback the transaction
-					return NewProcessingError(i+1, err)
-				}
-				row.Table = s[1]
-			case len(s) == 1:
-				// table name without schema, do nothing
-			default:
-				return fmt.Errorf("Table nmae is empty in yaml")
-		}
-
-		// Run a SELECT query to find out if we need to insert or UPDATE
-		selectQuery := fmt.Sprintf(
-			`SELECT COUNT(*) FROM "%s" WHERE %s`,
-			row.Table,
-			row.GetWhere(driver, 0),
-		)
-		var count int
-		if err := tx.QueryRow(selectQuery, row.GetPKValues()...).Scan(&count); err != nil {
-			tx.Rollback() // rollback the transaction
-			return NewProcessingError(i+1, err)
+	trackTable := func(table TableRef) {
+		if !seenTables[table.Schema+"."+table.Table] {
+			seenTables[table.Schema+"."+table.Table] = true
+			touchedTables = append(touchedTables, table)
 		}
+	}
 
-		if count == 0 {
-			// Primary key not found, let's run an INSERT query
-			insertQuery := fmt.Sprintf(
-				`INSERT INTO "%s"(%s) VALUES(%s)`,
-				row.Table,
-				strings.Join(row.GetInsertColumns(), ", "),
-				strings.Join(row.GetInsertPlaceholders(driver), ", "),
-			)
-			_, err := tx.Exec(insertQuery, row.GetInsertValues()...)
-			if err != nil {
-				tx.Rollback() // rollback the transaction
-				return NewProcessingError(i+1, err)
-			}
-			if driver == postgresDriver && row.GetInsertColumns()[0] == "\"id\"" {
-				err = fixPostgresPKSequence(tx, row.Table, "id")
+	if doOneTransactionPerRow {
+		for i, row := range rows {
+			row := row
+			if err := withTx(db, helper, func(tx *sql.Tx) error {
+				table, err := loadOneRow(tx, driver, helper, registry, &row, tableRowCount, i)
 				if err != nil {
-					tx.Rollback()
-					return NewProcessingError(i+1, err)
+					return err
 				}
+				trackTable(table)
+				return nil
+			}); err != nil {
+				return err
 			}
-		} else {
-			// Primary key found, let's run UPDATE query
-			updateQuery := fmt.Sprintf(
-				`UPDATE "%s" SET %s WHERE %s`,
-				row.Table,
-				strings.Join(row.GetUpdatePlaceholders(driver), ", "),
-				row.GetWhere(driver, row.GetUpdateValuesLength()),
-			)
-			values := append(row.GetUpdateValues(), row.GetPKValues()...)
-			_, err := tx.Exec(updateQuery, values...)
+		}
+
+		if len(touchedTables) > 0 {
+			afterTx, err := db.Begin()
 			if err != nil {
-				tx.Rollback() // rollback the transaction
-				return NewProcessingError(i+1, err)
+				return err
 			}
-			if driver == postgresDriver && row.GetUpdateColumns()[0] == "\"id\"" {
-				err = fixPostgresPKSequence(tx, row.Table, "id")
-				if err != nil {
-					tx.Rollback()
-					return NewProcessingError(i+1, err)
-				}
+			if err := helper.AfterLoad(afterTx, touchedTables); err != nil {
+				afterTx.Rollback()
+				return err
 			}
+			return afterTx.Commit()
 		}
 
-		if doOneTransactionPerRow {
-			// Commit the transaction
-			if err := tx.Commit(); err != nil {
-				tx.Rollback() // rollback the transaction
+		return nil
+	}
+
+	return withTx(db, helper, func(tx *sql.Tx) error {
+		// Iterate over rows defined in the fixture
+		for i, row := range rows {
+			table, err := loadOneRow(tx, driver, helper, registry, &row, tableRowCount, i)
+			if err != nil {
 				return err
 			}
+			trackTable(table)
 		}
+
+		return helper.AfterLoad(tx, touchedTables)
+	})
+}
+
+// loadOneRow inserts or updates a single row within tx, recording its
+// primary key in registry under "table/label" (label being the row's
+// 0-based position among rows loaded so far for its table, tracked via
+// tableRowCount) so that the `ref` template func can resolve it. rowNum is
+// the row's 0-based position in the fixture, used for error messages.
+func loadOneRow(tx *sql.Tx, driver string, helper Helper, registry *refRegistry, row *Row, tableRowCount map[string]int, rowNum int) (TableRef, error) {
+	row.Init()
+	s := strings.Split(row.Table, ".")
+	var schema string
+	switch {
+	case len(s) > 2:
+		return TableRef{}, fmt.Errorf("Table name wrong format in yaml")
+	case len(s) == 2:
+		q := fmt.Sprintf(`SET LOCAL SEARCH_PATH TO %s`, s[0])
+		if _, err := tx.Exec(q); err != nil {
+			return TableRef{}, NewProcessingError(rowNum+1, err)
+		}
+		schema = s[0]
+		row.Table = s[1]
+	case len(s) == 1:
+		// table name without schema, do nothing
+	default:
+		return TableRef{}, fmt.Errorf("Table nmae is empty in yaml")
+	}
+
+	// Run a SELECT query to find out if we need to insert or UPDATE
+	selectQuery := fmt.Sprintf(
+		`SELECT COUNT(*) FROM %s WHERE %s`,
+		helper.QuoteKeyword(row.Table),
+		requoteIdentifiers(helper, row.GetWhere(driver, 0)),
+	)
+	var count int
+	if err := tx.QueryRow(selectQuery, row.GetPKValues()...).Scan(&count); err != nil {
+		return TableRef{}, NewProcessingError(rowNum+1, err)
 	}
 
-	if !doOneTransactionPerRow {
-		if err := tx.Commit(); err != nil {
-			tx.Rollback() // rollback the transaction
+	label := strconv.Itoa(tableRowCount[row.Table])
+	tableRowCount[row.Table]++
+
+	if count == 0 {
+		// Primary key not found, let's run an INSERT query
+		insertColumns := make([]string, len(row.GetInsertColumns()))
+		for i, column := range row.GetInsertColumns() {
+			insertColumns[i] = requoteColumn(helper, column)
+		}
+		insertQuery := fmt.Sprintf(
+			`INSERT INTO %s(%s) VALUES(%s)`,
+			helper.QuoteKeyword(row.Table),
+			strings.Join(insertColumns, ", "),
+			strings.Join(row.GetInsertPlaceholders(driver), ", "),
+		)
+		err := helper.WhileInsertOnTable(tx, row.Table, func() error {
+			_, err := tx.Exec(insertQuery, row.GetInsertValues()...)
 			return err
+		})
+		if err != nil {
+			return TableRef{}, NewProcessingError(rowNum+1, err)
+		}
+	} else {
+		// Primary key found, let's run UPDATE query
+		updateQuery := fmt.Sprintf(
+			`UPDATE %s SET %s WHERE %s`,
+			helper.QuoteKeyword(row.Table),
+			requoteIdentifiers(helper, strings.Join(row.GetUpdatePlaceholders(driver), ", ")),
+			requoteIdentifiers(helper, row.GetWhere(driver, row.GetUpdateValuesLength())),
+		)
+		values := append(row.GetUpdateValues(), row.GetPKValues()...)
+		if _, err := tx.Exec(updateQuery, values...); err != nil {
+			return TableRef{}, NewProcessingError(rowNum+1, err)
 		}
 	}
 
-	return nil
+	if pk := row.GetPKValues(); len(pk) > 0 {
+		registry.set(row.Table, label, pk[0])
+	}
+
+	return TableRef{Schema: schema, Table: row.Table}, nil
+}
+
+// splitTableSchema splits a fixture's "schema.table" (or plain "table")
+// into a TableRef.
+func splitTableSchema(table string) TableRef {
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		return TableRef{Schema: table[:i], Table: table[i+1:]}
+	}
+	return TableRef{Table: table}
+}
+
+// parseRows unmarshals data as a YAML fixture without loading it, for
+// callers that only need to inspect which tables/rows it contains.
+func parseRows(data []byte) ([]Row, error) {
+	var rows []Row
+	if err := yaml.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
 }
 
 // LoadFile ...
@@ -165,27 +234,71 @@ func LoadFiles(filenames []string, db *sql.DB, driver string) error {
 	return nil
 }
 
-// fixPostgresPKSequence
-func fixPostgresPKSequence(tx *sql.Tx, table string, column string) error {
+// fixPostgresSequences brings every serial/identity column's sequence on
+// table up to date with the rows just loaded, instead of assuming the
+// primary key column is always called "id". A column is considered
+// sequence-backed if pg_get_serial_sequence resolves one for it; columns
+// that aren't (e.g. plain integers, or natural keys) are skipped.
+func fixPostgresSequences(tx *sql.Tx, table TableRef) error {
+	rows, err := tx.Query(`
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema()) AND table_name = $2
+	`, table.Schema, table.Table)
+	if err != nil {
+		return err
+	}
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if err := fixPostgresPKSequence(tx, table, column); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fixPostgresPKSequence updates the sequence backing table's column, if
+// any, to the column's current maximum value.
+func fixPostgresPKSequence(tx *sql.Tx, table TableRef, column string) error {
 	// Query for the qualified sequence name
 	var seqName *string
 	err := tx.QueryRow(`
 		SELECT pg_get_serial_sequence($1, $2)
-	`, table, column).Scan(&seqName)
+	`, table.Qualified(), column).Scan(&seqName)
 
 	if err != nil {
 		return err
 	}
 
 	if seqName == nil {
-		// No sequence to fix
+		// Not a sequence-backed column, nothing to fix
 		return nil
 	}
 
-	// Set the sequence
+	// Set the sequence to the column's current max (or 1 if the table is
+	// empty), matching what a fresh sequence would have produced.
+	helper := &postgresHelper{}
+	quotedColumn := helper.QuoteKeyword(column)
+	quotedTable := table.Quoted(helper)
 	_, err = tx.Exec(fmt.Sprintf(`
-		SELECT pg_catalog.setval($1, (SELECT MAX("%s") FROM "%s"))
-	`, column, table), *seqName)
+		SELECT pg_catalog.setval($1, COALESCE((SELECT MAX(%s) FROM %s), 1), (SELECT MAX(%s) FROM %s) IS NOT NULL)
+	`, quotedColumn, quotedTable, quotedColumn, quotedTable), *seqName)
 
 	return err
 }
@@ -0,0 +1,18 @@
+package fixtures
+
+import "testing"
+
+func TestSplitTableSchema(t *testing.T) {
+	cases := []struct {
+		table string
+		want  TableRef
+	}{
+		{"users", TableRef{Table: "users"}},
+		{"tenant1.users", TableRef{Schema: "tenant1", Table: "users"}},
+	}
+	for _, c := range cases {
+		if got := splitTableSchema(c.table); got != c.want {
+			t.Errorf("splitTableSchema(%q) = %+v, want %+v", c.table, got, c.want)
+		}
+	}
+}
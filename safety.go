@@ -0,0 +1,96 @@
+package fixtures
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"regexp"
+)
+
+// defaultDatabaseNameRegexp is the pattern a database name must match
+// before Load/LoadFile will write to it, unless
+// LoadOptions.SkipDatabaseNameCheck is set or a different
+// LoadOptions.DatabaseNameRegexp is provided.
+var defaultDatabaseNameRegexp = regexp.MustCompile(`(?i)test`)
+
+// skipDatabaseNameCheckEnvVar lets Load/LoadFile/LoadFiles callers opt out
+// of the EnsureTestDatabase safety check without having to switch to
+// LoadWithOptions, for existing test databases whose name doesn't match
+// defaultDatabaseNameRegexp (e.g. "myapp_ci"). Callers that already use
+// LoadOptions should set SkipDatabaseNameCheck there instead.
+const skipDatabaseNameCheckEnvVar = "FIXTURES_SKIP_DB_CHECK"
+
+// skipDatabaseNameCheck reports whether skipDatabaseNameCheckEnvVar is set
+// to a non-empty value.
+func skipDatabaseNameCheck() bool {
+	return os.Getenv(skipDatabaseNameCheckEnvVar) != ""
+}
+
+// ErrNotTestDatabase is returned when the target database's name doesn't
+// match the expected test-database pattern, to guard against accidentally
+// loading fixtures into a production database.
+var ErrNotTestDatabase = errors.New("fixtures: database name doesn't look like a test database")
+
+// EnsureTestDatabase checks that the database db is connected to looks like
+// a test database, per databaseNameRegexp (or defaultDatabaseNameRegexp if
+// nil). It returns ErrNotTestDatabase if not.
+func EnsureTestDatabase(db *sql.DB, driver string, databaseNameRegexp *regexp.Regexp) error {
+	if databaseNameRegexp == nil {
+		databaseNameRegexp = defaultDatabaseNameRegexp
+	}
+
+	name, err := currentDatabaseName(db, driver)
+	if err != nil {
+		return err
+	}
+
+	if !databaseNameRegexp.MatchString(name) {
+		return ErrNotTestDatabase
+	}
+
+	return nil
+}
+
+// currentDatabaseName returns the name of the database db is currently
+// connected to.
+func currentDatabaseName(db *sql.DB, driver string) (string, error) {
+	switch driver {
+	case postgresDriver:
+		var name string
+		err := db.QueryRow(`SELECT current_database()`).Scan(&name)
+		return name, err
+	case mysqlDriver:
+		var name string
+		err := db.QueryRow(`SELECT DATABASE()`).Scan(&name)
+		return name, err
+	case mssqlDriver:
+		var name string
+		err := db.QueryRow(`SELECT DB_NAME()`).Scan(&name)
+		return name, err
+	case sqliteDriver:
+		// PRAGMA database_list returns one row per attached database;
+		// the main database's file path doubles as its name.
+		rows, err := db.Query(`PRAGMA database_list`)
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+
+		var seq int
+		var name, file string
+		for rows.Next() {
+			if err := rows.Scan(&seq, &name, &file); err != nil {
+				return "", err
+			}
+			if name == "main" {
+				if file != "" {
+					return file, nil
+				}
+				return name, nil
+			}
+		}
+		return "", rows.Err()
+	default:
+		return "", errUnsupportedDriver(driver)
+	}
+}
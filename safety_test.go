@@ -0,0 +1,29 @@
+package fixtures
+
+import "testing"
+
+func TestSkipDatabaseNameCheck(t *testing.T) {
+	t.Setenv(skipDatabaseNameCheckEnvVar, "")
+	if skipDatabaseNameCheck() {
+		t.Error("skipDatabaseNameCheck() = true with env var empty, want false")
+	}
+
+	t.Setenv(skipDatabaseNameCheckEnvVar, "1")
+	if !skipDatabaseNameCheck() {
+		t.Error("skipDatabaseNameCheck() = false with env var set, want true")
+	}
+}
+
+func TestDefaultDatabaseNameRegexp(t *testing.T) {
+	cases := map[string]bool{
+		"myapp_test": true,
+		"TEST_myapp": true,
+		"myapp_ci":   false,
+		"production": false,
+	}
+	for name, want := range cases {
+		if got := defaultDatabaseNameRegexp.MatchString(name); got != want {
+			t.Errorf("defaultDatabaseNameRegexp.MatchString(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
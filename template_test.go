@@ -0,0 +1,57 @@
+package fixtures
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitFixtureDocuments(t *testing.T) {
+	data := []byte(`- table: users
+  id: 1
+  name: Alice
+- table: users
+  id: 2
+  name: Bob
+`)
+
+	docs, err := splitFixtureDocuments(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("got %d documents, want 2", len(docs))
+	}
+
+	want := []string{
+		"- table: users\n  id: 1\n  name: Alice",
+		"- table: users\n  id: 2\n  name: Bob",
+	}
+	for i, doc := range docs {
+		if string(doc) != want[i] {
+			t.Errorf("doc %d = %q, want %q", i, doc, want[i])
+		}
+	}
+}
+
+func TestSplitFixtureDocumentsEmpty(t *testing.T) {
+	if _, err := splitFixtureDocuments([]byte("\n\n")); err == nil {
+		t.Error("splitFixtureDocuments on empty input = nil error, want one")
+	}
+}
+
+func TestRefRegistry(t *testing.T) {
+	registry := newRefRegistry()
+
+	if _, err := registry.get("users", "0"); err == nil {
+		t.Error("get on empty registry = nil error, want one")
+	}
+
+	registry.set("users", "0", 42)
+	got, err := registry.get("users", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, 42) {
+		t.Errorf("get(\"users\", \"0\") = %v, want 42", got)
+	}
+}